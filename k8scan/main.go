@@ -19,14 +19,11 @@ import (
 	"time"
 
 	"github.com/Sirupsen/logrus"
-	mailgun "github.com/mailgun/mailgun-go"
 )
 
 const (
 	defaultCIDR = "0.0.0.0/0"
 
-	arinAPIEndpoint = "http://whois.arin.net/rest/ip/%s"
-
 	emailSender = "k8scan@jessfraz.com"
 )
 
@@ -43,6 +40,13 @@ var (
 	mailgunAPIKey  string
 	emailRecipient string
 
+	outputFormat string
+	outputPath   string
+	outputFile   *os.File
+	outputMu     sync.Mutex
+
+	activeNotifiers []Notifier
+
 	debug bool
 )
 
@@ -114,21 +118,49 @@ func init() {
 	flag.StringVar(&mailgunDomain, "mailgun-domain", "", "Mailgun Domain to use for sending email (optional)")
 	flag.StringVar(&emailRecipient, "email-recipient", "", "Recipient for email notifications (optional)")
 
+	flag.StringVar(&outputFormat, "output", "text", "Output format for findings: text, json, or ndjson")
+	flag.StringVar(&outputPath, "output-file", "", "File to additionally stream findings to, in the format given by -output (optional)")
+
 	flag.BoolVar(&debug, "d", false, "Run in debug mode")
 
 	flag.Usage = func() {
 		flag.PrintDefaults()
 	}
+}
 
+// parseFlags parses the command line flags and validates/applies the ones
+// that depend on each other. It is called from main, after every package
+// file's init has had a chance to register its flags.
+func parseFlags() {
 	flag.Parse()
 
 	// set log level
 	if debug {
 		logrus.SetLevel(logrus.DebugLevel)
 	}
+
+	switch outputFormat {
+	case "text", "json", "ndjson":
+	default:
+		logrus.Fatalf("unknown -output format %q, must be one of: text, json, ndjson", outputFormat)
+	}
+
+	if len(outputPath) > 0 {
+		f, err := os.OpenFile(outputPath, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+		if err != nil {
+			logrus.Fatalf("opening output file %s failed: %v", outputPath, err)
+		}
+		outputFile = f
+	}
+
+	initWhoisCache()
 }
 
 func main() {
+	parseFlags()
+
+	activeNotifiers = notifiers()
+
 	// On ^C, or SIGTERM handle exit.
 	c := make(chan os.Signal, 1)
 	signal.Notify(c, os.Interrupt)
@@ -136,6 +168,11 @@ func main() {
 	go func() {
 		for sig := range c {
 			logrus.Infof("Received %s, exiting.", sig.String())
+			if len(stateFilePath) > 0 {
+				saveCheckpoint()
+			}
+			saveWhoisCacheIfDirty()
+			waitNotifications()
 			os.Exit(0)
 		}
 	}()
@@ -146,10 +183,11 @@ func main() {
 	log.SetOutput(ioutil.Discard)
 
 	logrus.Infof("Scanning for Kubernetes Dashboards and API Servers on %s over port range %#v", cidr, ports)
-	if len(mailgunDomain) > 0 && len(mailgunAPIKey) > 0 && len(emailRecipient) > 0 {
+	if hasNotifier(activeNotifiers, "mailgun") {
 		logrus.Infof("Using Mailgun Domain %s, API Key %s to send emails to %s", mailgunDomain, mailgunAPIKey, emailRecipient)
 	}
-	logrus.Infof("This may take a bit...")
+
+	serveMetrics()
 
 	startTime := time.Now()
 
@@ -158,25 +196,32 @@ func main() {
 		logrus.Fatal(err)
 	}
 
-	var wg sync.WaitGroup
-	for ip := ip.Mask(ipnet.Mask); ipnet.Contains(ip); inc(ip) {
-		for _, port := range ports {
-			wg.Add(1)
-			go func(ip string, port int) {
-				defer wg.Done()
+	runScan(ip, ipnet, ports)
+	waitNotifications()
+	saveWhoisCacheIfDirty()
 
-				scanIP(ip, port)
-
-			}(ip.String(), port)
-		}
+	if outputFile != nil {
+		outputFile.Close()
 	}
 
-	wg.Wait()
-
 	since := time.Since(startTime)
 	logrus.Infof("Scan took: %s", since.String())
 }
 
+// Finding describes a single positive scan result, in a form suitable for
+// human-readable printing as well as JSON/NDJSON serialization.
+type Finding struct {
+	IP         string         `json:"ip"`
+	Port       int            `json:"port"`
+	URL        string         `json:"url"`
+	Signature  string         `json:"signature"`
+	Status     int            `json:"status"`
+	TLS        bool           `json:"tls"`
+	Whois      WhoisInfo      `json:"whois"`
+	Kubernetes KubernetesInfo `json:"kubernetes"`
+	Timestamp  time.Time      `json:"timestamp"`
+}
+
 func scanIP(ip string, port int) {
 	// Check if the port is open.
 	ok := portOpen(ip, port)
@@ -185,7 +230,7 @@ func scanIP(ip string, port int) {
 	}
 
 	// Check if it's a kubernetes dashboard.
-	ok, uri := isKubernetesDashboard(ip, port)
+	ok, uri, signature, status := isKubernetesDashboard(ip, port)
 	if !ok {
 		return
 	}
@@ -196,20 +241,64 @@ func scanIP(ip string, port int) {
 		logrus.Warnf("ip info err: %v", err)
 	}
 
-	fmt.Printf("%s:%d\t%s\t%s\t%s\n",
-		ip, port,
-		info.Net.Organization.Handle, info.Net.Organization.Name, info.Net.Organization.Reference)
+	finding := Finding{
+		IP:         ip,
+		Port:       port,
+		URL:        uri,
+		Signature:  signature,
+		Status:     status,
+		TLS:        strings.HasPrefix(uri, "https://"),
+		Whois:      info,
+		Kubernetes: probeKubernetes(baseURL(uri)),
+		Timestamp:  time.Now(),
+	}
+
+	writeFinding(finding)
+
+	notifyAll(activeNotifiers, finding)
+}
 
-	// send an email
-	if len(mailgunDomain) > 0 && len(mailgunAPIKey) > 0 && len(emailRecipient) > 0 {
-		if err := sendEmail(uri, ip, port, info); err != nil {
-			logrus.Warn(err)
+// writeFinding renders a Finding according to -output and writes it to
+// stdout, as well as to -output-file if one was given.
+func writeFinding(f Finding) {
+	var line string
+	switch outputFormat {
+	case "json":
+		b, err := json.MarshalIndent(f, "", "  ")
+		if err != nil {
+			logrus.Warnf("marshaling finding failed: %v", err)
+			return
+		}
+		line = string(b) + "\n"
+	case "ndjson":
+		b, err := json.Marshal(f)
+		if err != nil {
+			logrus.Warnf("marshaling finding failed: %v", err)
+			return
+		}
+		line = string(b) + "\n"
+	default:
+		line = fmt.Sprintf("%s:%d\t%s\t%s\t%s\n",
+			f.IP, f.Port,
+			f.Whois.Handle, f.Whois.Name, f.Whois.Reference)
+	}
+
+	outputMu.Lock()
+	defer outputMu.Unlock()
+
+	fmt.Print(line)
+
+	if outputFile != nil {
+		if _, err := outputFile.WriteString(line); err != nil {
+			logrus.Warnf("writing to output file failed: %v", err)
 		}
 	}
 }
 
 func portOpen(ip string, port int) bool {
+	start := time.Now()
 	c, err := net.DialTimeout("tcp", fmt.Sprintf("%s:%d", ip, port), timeoutPing)
+	portOpenDuration.Observe(time.Since(start).Seconds())
 	if err != nil {
 		logrus.Debugf("listen at %s:%s failed: %v", ip, port, err)
 		return false
@@ -218,10 +307,19 @@ func portOpen(ip string, port int) bool {
 		c.Close()
 	}
 
+	openPortsTotal.Inc()
 	return true
 }
 
-func isKubernetesDashboard(ip string, port int) (bool, string) {
+// Signature names for the heuristics used by isKubernetesDashboard, exposed
+// so findings can record exactly which one fired.
+const (
+	signatureDashboardHTML = "dashboard-html"
+	signatureAPIVersions   = "api-versions"
+	signatureAPIPaths      = "api-paths"
+)
+
+func isKubernetesDashboard(ip string, port int) (bool, string, string, int) {
 	client := &http.Client{
 		Timeout: timeoutGet,
 		Transport: &http.Transport{
@@ -244,66 +342,39 @@ func isKubernetesDashboard(ip string, port int) (bool, string) {
 		uri  string
 	)
 
+	start := time.Now()
 	for i := 0; i < len(tryAddrs) && err != nil; i++ {
 		uri = tryAddrs[i]
 		resp, err = client.Get(uri)
 	}
+	dashboardCheckDuration.Observe(time.Since(start).Seconds())
 	if err != nil {
 		logrus.Debugf("getting %s:%s failed: %v", ip, port, err)
-		return false, ""
+		httpErrorsTotal.WithLabelValues("dashboard-check").Inc()
+		return false, "", "", 0
 	}
 	defer resp.Body.Close()
 
 	b, err := ioutil.ReadAll(resp.Body)
 	if err != nil {
-		return false, ""
+		httpErrorsTotal.WithLabelValues("dashboard-check-body").Inc()
+		return false, "", "", resp.StatusCode
 	}
 
 	body := strings.ToLower(string(b))
-	if (strings.Contains(body, "kubernetes") && strings.Contains(body, "dashboard")) ||
-		(strings.Contains(body, `"versions"`) && strings.Contains(body, `"serverAddress`)) ||
-		(strings.Contains(body, `"paths"`) && strings.Contains(body, `"/api"`)) {
-		return true, uri
-	}
-
-	return false, ""
-}
-
-// ARINResponse describes the data struct that holds the response from ARIN.
-type ARINResponse struct {
-	Net NetJSON `json:"net,omitempty"`
-}
-
-// NetJSON holds the net data from the ARIN response.
-type NetJSON struct {
-	Organization OrganizationJSON `json:"orgRef,omitempty"`
-}
-
-// OrganizationJSON holds the organization data from the ARIN response.
-type OrganizationJSON struct {
-	Handle    string `json:"@handle,omitempty"`
-	Name      string `json:"@name,omitempty"`
-	Reference string `json:"$,omitempty"`
-}
-
-func getIPInfo(ip string) (b ARINResponse, err error) {
-	req, err := http.NewRequest(http.MethodGet, fmt.Sprintf(arinAPIEndpoint, ip), nil)
-	if err != nil {
-		return b, err
-	}
-	req.Header.Set("Accept", "application/json")
-
-	resp, err := http.DefaultClient.Do(req)
-	if err != nil {
-		return b, err
-	}
-	defer resp.Body.Close()
-
-	if err := json.NewDecoder(resp.Body).Decode(&b); err != nil {
-		return b, err
+	switch {
+	case strings.Contains(body, "kubernetes") && strings.Contains(body, "dashboard"):
+		dashboardsFoundTotal.WithLabelValues(signatureDashboardHTML).Inc()
+		return true, uri, signatureDashboardHTML, resp.StatusCode
+	case strings.Contains(body, `"versions"`) && strings.Contains(body, `"serverAddress`):
+		dashboardsFoundTotal.WithLabelValues(signatureAPIVersions).Inc()
+		return true, uri, signatureAPIVersions, resp.StatusCode
+	case strings.Contains(body, `"paths"`) && strings.Contains(body, `"/api"`):
+		dashboardsFoundTotal.WithLabelValues(signatureAPIPaths).Inc()
+		return true, uri, signatureAPIPaths, resp.StatusCode
 	}
 
-	return b, nil
+	return false, "", "", resp.StatusCode
 }
 
 func inc(ip net.IP) {
@@ -315,10 +386,10 @@ func inc(ip net.IP) {
 	}
 }
 
-func sendEmail(uri, ip string, port int, arinInfo ARINResponse) error {
-	mailgunClient := mailgun.NewMailgun(mailgunDomain, mailgunAPIKey, "")
+func sendEmail(uri, ip string, port int, whois WhoisInfo) error {
+	client := mailgunClient()
 
-	msg, _, err := mailgunClient.Send(mailgunClient.NewMessage(
+	msg, _, err := client.Send(client.NewMessage(
 		/* From */ fmt.Sprintf("%s <%s>", emailSender, emailSender),
 		/* Subject */ fmt.Sprintf("[k8scan]: found dashboard %s", uri),
 		/* Body */ fmt.Sprintf(`Time: %s
@@ -326,17 +397,18 @@ func sendEmail(uri, ip string, port int, arinInfo ARINResponse) error {
 IP: %s:%d
 URL: %s
 
-ARIN: %s
-	  %s
-	  %s
+Whois (%s): %s
+	        %s
+	        %s
 `,
 			time.Now().Format(time.UnixDate),
 			ip,
 			port,
 			uri,
-			arinInfo.Net.Organization.Handle,
-			arinInfo.Net.Organization.Name,
-			arinInfo.Net.Organization.Reference,
+			whois.Source,
+			whois.Handle,
+			whois.Name,
+			whois.Reference,
 		),
 		/* To */ emailRecipient,
 	))
@@ -0,0 +1,145 @@
+package main
+
+import (
+	"flag"
+	"net"
+	"net/http"
+	"sync/atomic"
+	"time"
+
+	"github.com/Sirupsen/logrus"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+const progressInterval = 30 * time.Second
+
+var metricsAddr string
+
+func init() {
+	flag.StringVar(&metricsAddr, "metrics-addr", "", "Address to serve Prometheus metrics on, ex. :9090 (optional)")
+}
+
+var (
+	portsScannedTotal = prometheus.NewCounter(prometheus.CounterOpts{
+		Name: "k8scan_ports_scanned_total",
+		Help: "Total number of (ip, port) targets scanned.",
+	})
+
+	openPortsTotal = prometheus.NewCounter(prometheus.CounterOpts{
+		Name: "k8scan_open_ports_total",
+		Help: "Total number of targets found with an open port.",
+	})
+
+	dashboardsFoundTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "k8scan_dashboards_found_total",
+		Help: "Total number of Kubernetes dashboards/API servers found, by detection signature.",
+	}, []string{"signature"})
+
+	httpErrorsTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "k8scan_http_errors_total",
+		Help: "Total number of HTTP errors encountered, by stage.",
+	}, []string{"kind"})
+
+	portOpenDuration = prometheus.NewHistogram(prometheus.HistogramOpts{
+		Name:    "k8scan_port_open_duration_seconds",
+		Help:    "Latency of the TCP dial used to check whether a port is open.",
+		Buckets: prometheus.DefBuckets,
+	})
+
+	dashboardCheckDuration = prometheus.NewHistogram(prometheus.HistogramOpts{
+		Name:    "k8scan_dashboard_check_duration_seconds",
+		Help:    "Latency of the GET request used to fingerprint a Kubernetes dashboard/API server.",
+		Buckets: prometheus.DefBuckets,
+	})
+)
+
+func init() {
+	prometheus.MustRegister(
+		portsScannedTotal,
+		openPortsTotal,
+		dashboardsFoundTotal,
+		httpErrorsTotal,
+		portOpenDuration,
+		dashboardCheckDuration,
+	)
+}
+
+// serveMetrics starts the Prometheus metrics HTTP endpoint if -metrics-addr
+// was given. It is fire-and-forget: a failure just gets logged, since
+// metrics are optional and shouldn't abort a scan.
+func serveMetrics() {
+	if len(metricsAddr) == 0 {
+		return
+	}
+
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", promhttp.Handler())
+
+	go func() {
+		if err := http.ListenAndServe(metricsAddr, mux); err != nil {
+			logrus.Warnf("metrics server on %s failed: %v", metricsAddr, err)
+		}
+	}()
+}
+
+// progressReporter tracks how many targets have finished scanning against
+// the total target space, logging periodic percent-complete/ETA updates
+// in place of the old one-shot "this may take a bit..." message.
+type progressReporter struct {
+	total     int64
+	scanned   int64
+	startTime time.Time
+}
+
+func newProgressReporter(ipnet *net.IPNet, numPorts int) *progressReporter {
+	ones, bits := ipnet.Mask.Size()
+	numIPs := int64(1) << uint(bits-ones)
+
+	return &progressReporter{
+		total:     numIPs * int64(numPorts),
+		startTime: time.Now(),
+	}
+}
+
+// recordScan marks one more target as scanned. Safe to call concurrently.
+func (p *progressReporter) recordScan() {
+	atomic.AddInt64(&p.scanned, 1)
+	portsScannedTotal.Inc()
+}
+
+// run logs progress every progressInterval until stop is closed.
+func (p *progressReporter) run(stop <-chan struct{}) {
+	ticker := time.NewTicker(progressInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			p.logProgress()
+		case <-stop:
+			return
+		}
+	}
+}
+
+func (p *progressReporter) logProgress() {
+	scanned := atomic.LoadInt64(&p.scanned)
+	if p.total <= 0 {
+		logrus.Infof("Scanned %d targets so far", scanned)
+		return
+	}
+
+	elapsed := time.Since(p.startTime)
+	percent := float64(scanned) / float64(p.total) * 100
+
+	rate := float64(scanned) / elapsed.Seconds()
+	var eta time.Duration
+	if rate > 0 {
+		remaining := float64(p.total - scanned)
+		eta = time.Duration(remaining/rate) * time.Second
+	}
+
+	logrus.Infof("Progress: %d/%d (%.2f%%) targets scanned, %.0f/s, ETA %s",
+		scanned, p.total, percent, rate, eta)
+}
@@ -0,0 +1,338 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"net"
+	"net/http"
+	"net/smtp"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Sirupsen/logrus"
+	mailgun "github.com/mailgun/mailgun-go"
+)
+
+const notifyTimeout = 10 * time.Second
+
+var (
+	notifyBackends notifyList
+
+	smtpAddr string
+	smtpFrom string
+	smtpTo   string
+
+	webhookURL string
+
+	slackWebhookURL string
+
+	notifyWG sync.WaitGroup
+)
+
+// notifyList is a comma-separated list of notifier backend names, in the
+// same style as intSlice is a comma-separated list of ports.
+type notifyList []string
+
+func (n *notifyList) String() string {
+	return strings.Join(*n, ",")
+}
+
+func (n *notifyList) Set(value string) error {
+	if len(value) <= 0 {
+		return nil
+	}
+	*n = strings.Split(value, ",")
+	return nil
+}
+
+func init() {
+	flag.Var(&notifyBackends, "notify", "Notification backends to use, comma separated (ex. mailgun,webhook,slack)")
+
+	flag.StringVar(&smtpAddr, "smtp-addr", "", "SMTP server address (host:port) to send email through instead of Mailgun (optional)")
+	flag.StringVar(&smtpFrom, "smtp-from", emailSender, "From address for SMTP email notifications")
+	flag.StringVar(&smtpTo, "smtp-to", "", "Recipient for SMTP email notifications (optional)")
+
+	flag.StringVar(&webhookURL, "webhook-url", "", "URL to POST a JSON finding to (optional)")
+
+	flag.StringVar(&slackWebhookURL, "slack-webhook-url", "", "Slack incoming webhook URL to post findings to (optional)")
+}
+
+// Notifier is implemented by anything that can be told about a Finding.
+type Notifier interface {
+	// Name returns the notifier's name, used in log messages.
+	Name() string
+	// Notify sends the finding via this backend.
+	Notify(ctx context.Context, f Finding) error
+}
+
+// notifiers returns the set of configured Notifiers based on -notify and
+// the per-backend flags. It is built once flags have been parsed.
+func notifiers() []Notifier {
+	var ns []Notifier
+	for _, name := range notifyBackends {
+		switch strings.TrimSpace(name) {
+		case "mailgun":
+			if len(mailgunDomain) == 0 || len(mailgunAPIKey) == 0 || len(emailRecipient) == 0 {
+				logrus.Warn("mailgun notifier requested but -mailgun-domain, -mailgun-api-key, or -email-recipient is missing")
+				continue
+			}
+			ns = append(ns, &mailgunNotifier{})
+		case "smtp":
+			if len(smtpAddr) == 0 || len(smtpTo) == 0 {
+				logrus.Warn("smtp notifier requested but -smtp-addr or -smtp-to is missing")
+				continue
+			}
+			ns = append(ns, &smtpNotifier{})
+		case "webhook":
+			if len(webhookURL) == 0 {
+				logrus.Warn("webhook notifier requested but -webhook-url is missing")
+				continue
+			}
+			ns = append(ns, &webhookNotifier{})
+		case "slack":
+			if len(slackWebhookURL) == 0 {
+				logrus.Warn("slack notifier requested but -slack-webhook-url is missing")
+				continue
+			}
+			ns = append(ns, &slackNotifier{})
+		default:
+			logrus.Warnf("unknown notifier %q, skipping", name)
+		}
+	}
+	return ns
+}
+
+// hasNotifier reports whether ns includes a notifier with the given name.
+func hasNotifier(ns []Notifier, name string) bool {
+	for _, n := range ns {
+		if n.Name() == name {
+			return true
+		}
+	}
+	return false
+}
+
+// notifyAll fans out a finding to every configured notifier concurrently,
+// bounding each by notifyTimeout and logging any errors. It tracks the
+// fan-out with notifyWG so waitNotifications can drain it before the
+// process exits, instead of leaving notifications to be killed mid-flight.
+func notifyAll(ns []Notifier, f Finding) {
+	for _, n := range ns {
+		notifyWG.Add(1)
+		go func(n Notifier) {
+			defer notifyWG.Done()
+
+			ctx, cancel := context.WithTimeout(context.Background(), notifyTimeout)
+			defer cancel()
+
+			if err := n.Notify(ctx, f); err != nil {
+				logrus.Warnf("%s notifier failed: %v", n.Name(), err)
+			}
+		}(n)
+	}
+}
+
+// waitNotifications blocks until every notification handed to notifyAll
+// so far has finished (or hit notifyTimeout). Called before main exits so
+// findings from the tail of a scan aren't dropped.
+func waitNotifications() {
+	notifyWG.Wait()
+}
+
+// mailgunNotifier sends email through Mailgun.
+type mailgunNotifier struct{}
+
+func (n *mailgunNotifier) Name() string { return "mailgun" }
+
+func (n *mailgunNotifier) Notify(ctx context.Context, f Finding) error {
+	return runWithContext(ctx, func() error {
+		return sendEmail(f.URL, f.IP, f.Port, f.Whois)
+	})
+}
+
+// smtpNotifier sends email through a plain SMTP server, useful for local
+// MTAs or Mailpit-style testing instead of a hosted provider.
+type smtpNotifier struct{}
+
+func (n *smtpNotifier) Name() string { return "smtp" }
+
+func (n *smtpNotifier) Notify(ctx context.Context, f Finding) error {
+	msg := fmt.Sprintf("From: %s\r\nTo: %s\r\nSubject: [k8scan]: found dashboard %s\r\n\r\n%s",
+		smtpFrom, smtpTo, f.URL, findingBody(f))
+
+	if err := sendSMTP(ctx, smtpAddr, smtpFrom, []string{smtpTo}, []byte(msg)); err != nil {
+		return fmt.Errorf("sending SMTP message failed: %v", err)
+	}
+
+	return nil
+}
+
+// sendSMTP is smtp.SendMail, but dialing through ctx and applying its
+// deadline to the connection, so a dead or slow-walking SMTP server can't
+// block past notifyTimeout.
+func sendSMTP(ctx context.Context, addr, from string, to []string, msg []byte) error {
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		return err
+	}
+
+	var d net.Dialer
+	conn, err := d.DialContext(ctx, "tcp", addr)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	if deadline, ok := ctx.Deadline(); ok {
+		if err := conn.SetDeadline(deadline); err != nil {
+			return err
+		}
+	}
+
+	c, err := smtp.NewClient(conn, host)
+	if err != nil {
+		return err
+	}
+	defer c.Close()
+
+	if err := c.Mail(from); err != nil {
+		return err
+	}
+	for _, rcpt := range to {
+		if err := c.Rcpt(rcpt); err != nil {
+			return err
+		}
+	}
+
+	w, err := c.Data()
+	if err != nil {
+		return err
+	}
+	if _, err := w.Write(msg); err != nil {
+		return err
+	}
+	if err := w.Close(); err != nil {
+		return err
+	}
+
+	return c.Quit()
+}
+
+// webhookNotifier POSTs the finding as JSON to an arbitrary HTTP endpoint.
+type webhookNotifier struct{}
+
+func (n *webhookNotifier) Name() string { return "webhook" }
+
+func (n *webhookNotifier) Notify(ctx context.Context, f Finding) error {
+	b, err := json.Marshal(f)
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequest(http.MethodPost, webhookURL, bytes.NewReader(b))
+	if err != nil {
+		return err
+	}
+	req = req.WithContext(ctx)
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("posting webhook failed: %v", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode >= 400 {
+		return fmt.Errorf("webhook returned status %s", resp.Status)
+	}
+
+	return nil
+}
+
+// slackNotifier posts the finding to a Slack incoming webhook.
+type slackNotifier struct{}
+
+func (n *slackNotifier) Name() string { return "slack" }
+
+func (n *slackNotifier) Notify(ctx context.Context, f Finding) error {
+	payload := struct {
+		Text string `json:"text"`
+	}{
+		Text: fmt.Sprintf("found dashboard %s\n```%s```", f.URL, findingBody(f)),
+	}
+
+	b, err := json.Marshal(payload)
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequest(http.MethodPost, slackWebhookURL, bytes.NewReader(b))
+	if err != nil {
+		return err
+	}
+	req = req.WithContext(ctx)
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("posting to slack failed: %v", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode >= 400 {
+		return fmt.Errorf("slack webhook returned status %s", resp.Status)
+	}
+
+	return nil
+}
+
+// findingBody renders a Finding as the plain-text body shared by the SMTP
+// and Slack notifiers.
+func findingBody(f Finding) string {
+	return fmt.Sprintf(`Time: %s
+
+IP: %s:%d
+URL: %s
+
+Whois (%s): %s
+            %s
+            %s
+`,
+		f.Timestamp.Format(time.UnixDate),
+		f.IP,
+		f.Port,
+		f.URL,
+		f.Whois.Source,
+		f.Whois.Handle,
+		f.Whois.Name,
+		f.Whois.Reference,
+	)
+}
+
+// runWithContext runs fn in its own goroutine and returns its error, but
+// gives up and returns ctx.Err() once ctx is done. Used by notifiers built
+// on clients (like mailgun-go) that don't take a context themselves; the
+// abandoned call is left to finish on its own rather than being killed.
+func runWithContext(ctx context.Context, fn func() error) error {
+	done := make(chan error, 1)
+	go func() {
+		done <- fn()
+	}()
+
+	select {
+	case err := <-done:
+		return err
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// mailgunClient builds the Mailgun client used by mailgunNotifier and the
+// legacy sendEmail helper. A new client is built on every call.
+func mailgunClient() mailgun.Mailgun {
+	return mailgun.NewMailgun(mailgunDomain, mailgunAPIKey, "")
+}
@@ -0,0 +1,106 @@
+package main
+
+import (
+	"crypto/tls"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"strings"
+
+	"github.com/Sirupsen/logrus"
+)
+
+// KubernetesInfo holds the results of the follow-up reconnaissance done by
+// probeKubernetes once a dashboard or API server has already been found.
+type KubernetesInfo struct {
+	GitVersion     string   `json:"gitVersion,omitempty"`
+	AnonymousAuth  bool     `json:"anonymousAuth"`
+	APIGroups      []string `json:"apiGroups,omitempty"`
+	LoginSkippable bool     `json:"loginSkippable"`
+	HealthzOK      bool     `json:"healthzOk"`
+}
+
+// versionResponse mirrors the subset of k8s.io/apimachinery's version.Info
+// that we care about from GET /version.
+type versionResponse struct {
+	GitVersion string `json:"gitVersion"`
+}
+
+// apiGroupListResponse mirrors the subset of metav1.APIGroupList that we
+// care about from GET /apis.
+type apiGroupListResponse struct {
+	Groups []struct {
+		Name string `json:"name"`
+	} `json:"groups"`
+}
+
+// probeKubernetes performs follow-up requests against an API server or
+// dashboard found by isKubernetesDashboard, to turn a binary "is it a
+// dashboard" result into a small reconnaissance report.
+func probeKubernetes(base string) KubernetesInfo {
+	client := &http.Client{
+		Timeout: timeoutGet,
+		Transport: &http.Transport{
+			TLSClientConfig: &tls.Config{
+				InsecureSkipVerify: true,
+			},
+		},
+	}
+
+	var info KubernetesInfo
+
+	if b, _, err := probeGet(client, base+"/version"); err == nil {
+		var v versionResponse
+		if err := json.Unmarshal(b, &v); err == nil {
+			info.GitVersion = v.GitVersion
+		}
+	}
+
+	if _, status, err := probeGet(client, base+"/api/v1/namespaces"); err == nil {
+		info.AnonymousAuth = status == http.StatusOK
+	}
+
+	if b, _, err := probeGet(client, base+"/apis"); err == nil {
+		var groups apiGroupListResponse
+		if err := json.Unmarshal(b, &groups); err == nil {
+			for _, g := range groups.Groups {
+				info.APIGroups = append(info.APIGroups, g.Name)
+			}
+		}
+	}
+
+	if b, status, err := probeGet(client, base+"/healthz"); err == nil {
+		info.HealthzOK = status == http.StatusOK && strings.TrimSpace(string(b)) == "ok"
+	}
+
+	if b, _, err := probeGet(client, base); err == nil {
+		info.LoginSkippable = strings.Contains(strings.ToLower(string(b)), "skip")
+	}
+
+	return info
+}
+
+// baseURL strips any path isKubernetesDashboard's uri may carry (such as
+// "/api/") so probeKubernetes can build its own set of request paths.
+func baseURL(uri string) string {
+	return strings.TrimSuffix(strings.TrimSuffix(uri, "/"), "/api")
+}
+
+// probeGet issues a single GET and returns the body and status code,
+// logging (but not failing on) transport errors since probes are
+// best-effort.
+func probeGet(client *http.Client, url string) ([]byte, int, error) {
+	resp, err := client.Get(url)
+	if err != nil {
+		logrus.Debugf("probing %s failed: %v", url, err)
+		return nil, 0, err
+	}
+	defer resp.Body.Close()
+
+	b, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, resp.StatusCode, err
+	}
+
+	return b, resp.StatusCode, nil
+}
@@ -0,0 +1,241 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/Sirupsen/logrus"
+	"golang.org/x/time/rate"
+)
+
+const checkpointInterval = 10 * time.Second
+
+var (
+	workers           int
+	qps               float64
+	subnetConcurrency int
+
+	stateFilePath string
+	resume        bool
+
+	limiter *rate.Limiter
+
+	subnetSemsMu sync.Mutex
+	subnetSems   = map[string]chan struct{}{}
+
+	checkpointMu sync.Mutex
+	checkpoint   target
+	nextComplete uint64
+	pending      = map[uint64]target{}
+)
+
+// target is a single (ip, port) pair to scan. seq is its position in
+// generation order, used to track completion for checkpointing.
+type target struct {
+	IP   net.IP
+	Port int
+	seq  uint64
+}
+
+func init() {
+	flag.IntVar(&workers, "workers", 256, "Number of concurrent scan workers")
+	flag.Float64Var(&qps, "qps", 0, "Global rate limit in requests per second across all workers (0 means unlimited)")
+	flag.IntVar(&subnetConcurrency, "subnet-concurrency", 32, "Maximum concurrent scans per /24 subnet")
+
+	flag.StringVar(&stateFilePath, "state-file", "", "File to periodically checkpoint scan progress to (optional)")
+	flag.BoolVar(&resume, "resume", false, "Resume from the last checkpoint in -state-file, skipping already-scanned targets")
+}
+
+// scanState is what gets persisted to -state-file so a scan can be
+// resumed after a restart.
+type scanState struct {
+	IP   string `json:"ip"`
+	Port int    `json:"port"`
+}
+
+func loadState(path string) (scanState, error) {
+	var s scanState
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return s, err
+	}
+	if err := json.Unmarshal(b, &s); err != nil {
+		return s, err
+	}
+	return s, nil
+}
+
+func saveState(path string, t target) error {
+	b, err := json.Marshal(scanState{IP: t.IP.String(), Port: t.Port})
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(path, b, 0644)
+}
+
+// runScan walks the CIDR x ports space through a bounded worker pool,
+// rate limited globally and capped per /24 subnet, and checkpoints
+// progress to -state-file so a scan can be resumed later.
+func runScan(ip net.IP, ipnet *net.IPNet, ports []int) {
+	if qps > 0 {
+		limiter = rate.NewLimiter(rate.Limit(qps), 1)
+	}
+
+	var resumeFrom *scanState
+	if resume && len(stateFilePath) > 0 {
+		if s, err := loadState(stateFilePath); err == nil {
+			logrus.Infof("Resuming scan after %s:%d", s.IP, s.Port)
+			resumeFrom = &s
+		} else {
+			logrus.Warnf("could not load -state-file %s, starting from the beginning: %v", stateFilePath, err)
+		}
+	}
+
+	targets := make(chan target, workers*2)
+	go generateTargets(ip, ipnet, ports, resumeFrom, targets)
+
+	var stopCheckpoint chan struct{}
+	if len(stateFilePath) > 0 {
+		stopCheckpoint = make(chan struct{})
+		go checkpointLoop(stopCheckpoint)
+	}
+
+	progress := newProgressReporter(ipnet, len(ports))
+	stopProgress := make(chan struct{})
+	go progress.run(stopProgress)
+
+	var wg sync.WaitGroup
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for t := range targets {
+				if limiter != nil {
+					limiter.Wait(context.Background())
+				}
+
+				sem := subnetSemaphore(t.IP)
+				sem <- struct{}{}
+				scanIP(t.IP.String(), t.Port)
+				<-sem
+
+				completeTarget(t)
+				progress.recordScan()
+			}
+		}()
+	}
+
+	wg.Wait()
+	close(stopProgress)
+
+	if stopCheckpoint != nil {
+		close(stopCheckpoint)
+		saveCheckpoint()
+	}
+}
+
+// generateTargets walks the CIDR x ports space in scan order, skipping
+// anything at or before resumeFrom, and assigns each target a sequence
+// number so completeTarget can track which ones have actually finished.
+func generateTargets(ip net.IP, ipnet *net.IPNet, ports []int, resumeFrom *scanState, out chan<- target) {
+	defer close(out)
+
+	skipping := resumeFrom != nil
+	var seq uint64
+
+	for ip := ip.Mask(ipnet.Mask); ipnet.Contains(ip); inc(ip) {
+		for _, port := range ports {
+			if skipping {
+				if ip.String() == resumeFrom.IP && port == resumeFrom.Port {
+					skipping = false
+				}
+				continue
+			}
+
+			out <- target{IP: append(net.IP(nil), ip...), Port: port, seq: seq}
+			seq++
+		}
+	}
+}
+
+// completeTarget records that t has finished scanning, and advances the
+// checkpoint to the latest target for which every earlier-generated
+// target has also completed. This keeps the checkpoint from ever citing
+// a target that was merely dispatched (buffered or in flight) but never
+// actually scanned.
+func completeTarget(t target) {
+	checkpointMu.Lock()
+	defer checkpointMu.Unlock()
+
+	pending[t.seq] = t
+	for {
+		next, ok := pending[nextComplete]
+		if !ok {
+			break
+		}
+		checkpoint = next
+		delete(pending, nextComplete)
+		nextComplete++
+	}
+}
+
+// subnetSemaphore returns the concurrency-limiting channel for the /24
+// subnet that ip belongs to, creating it on first use.
+func subnetSemaphore(ip net.IP) chan struct{} {
+	key := subnetKey(ip)
+
+	subnetSemsMu.Lock()
+	defer subnetSemsMu.Unlock()
+
+	sem, ok := subnetSems[key]
+	if !ok {
+		sem = make(chan struct{}, subnetConcurrency)
+		subnetSems[key] = sem
+	}
+	return sem
+}
+
+// subnetKey returns the /24 a given IP belongs to, as a string, for use as
+// a concurrency-limiting map key.
+func subnetKey(ip net.IP) string {
+	v4 := ip.To4()
+	if v4 == nil {
+		// Not an IPv4 address, fall back to the full address as its own key.
+		return ip.String()
+	}
+	return fmt.Sprintf("%d.%d.%d.0/24", v4[0], v4[1], v4[2])
+}
+
+func checkpointLoop(stop <-chan struct{}) {
+	ticker := time.NewTicker(checkpointInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			saveCheckpoint()
+		case <-stop:
+			return
+		}
+	}
+}
+
+func saveCheckpoint() {
+	checkpointMu.Lock()
+	t := checkpoint
+	checkpointMu.Unlock()
+
+	if t.IP == nil {
+		return
+	}
+
+	if err := saveState(stateFilePath, t); err != nil {
+		logrus.Warnf("saving -state-file %s failed: %v", stateFilePath, err)
+	}
+}
@@ -0,0 +1,488 @@
+package main
+
+import (
+	"container/list"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/Sirupsen/logrus"
+)
+
+const whoisCacheSaveInterval = 10 * time.Second
+
+var (
+	whoisCacheFile string
+	whoisCacheSize int
+	whoisCacheTTL  time.Duration
+
+	whoisCacheSaveMu sync.Mutex // serializes writes to whoisCacheFile
+	whoisCacheDirty  int32      // set (atomically) whenever the cache changes since the last save
+)
+
+func init() {
+	flag.StringVar(&whoisCacheFile, "whois-cache-file", "", "File to persist the /24 whois cache to across runs (optional)")
+	flag.IntVar(&whoisCacheSize, "whois-cache-size", 4096, "Maximum number of /24s to keep in the whois cache")
+	flag.DurationVar(&whoisCacheTTL, "whois-cache-ttl", 24*time.Hour, "How long a cached whois lookup stays valid")
+}
+
+// WhoisInfo is the normalized result of a whois/RDAP lookup, regardless of
+// which registry or protocol answered it.
+type WhoisInfo struct {
+	Source    string `json:"source,omitempty"`
+	Handle    string `json:"handle,omitempty"`
+	Name      string `json:"name,omitempty"`
+	Reference string `json:"reference,omitempty"`
+	ASN       string `json:"asn,omitempty"`
+	ASName    string `json:"as_name,omitempty"`
+	Prefix    string `json:"prefix,omitempty"`
+	Country   string `json:"country,omitempty"`
+}
+
+// WhoisProvider looks up allocation info for an IP address.
+type WhoisProvider interface {
+	// Name identifies the provider in logs and the Source field.
+	Name() string
+	// Lookup fetches allocation info for ip.
+	Lookup(ip string) (WhoisInfo, error)
+}
+
+// rdapProvider queries a Regional Internet Registry's RDAP endpoint
+// (RFC 7483), which ARIN, RIPE, APNIC, LACNIC, and AFRINIC all expose in
+// the same shape, unlike their legacy whois/REST services.
+type rdapProvider struct {
+	name     string
+	endpoint string
+}
+
+func (p *rdapProvider) Name() string { return p.name }
+
+func (p *rdapProvider) Lookup(ip string) (WhoisInfo, error) {
+	var info WhoisInfo
+
+	req, err := http.NewRequest(http.MethodGet, fmt.Sprintf(p.endpoint, ip), nil)
+	if err != nil {
+		return info, err
+	}
+	req.Header.Set("Accept", "application/rdap+json")
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return info, err
+	}
+	defer resp.Body.Close()
+
+	var r struct {
+		Handle  string `json:"handle"`
+		Name    string `json:"name"`
+		Country string `json:"country"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&r); err != nil {
+		return info, err
+	}
+
+	info.Source = p.name
+	info.Handle = r.Handle
+	info.Name = r.Name
+	info.Country = r.Country
+	info.Reference = fmt.Sprintf(p.endpoint, ip)
+
+	return info, nil
+}
+
+// rirAllocation pairs a provider with the top-level CIDR blocks IANA has
+// delegated to it, so we can pick the right registry instead of always
+// asking ARIN.
+type rirAllocation struct {
+	provider WhoisProvider
+	nets     []*net.IPNet
+}
+
+var rirAllocations = buildRIRAllocations()
+
+// ianaIPv4Octets maps each RIR to the first octet of every /8 IANA's
+// IPv4 address space registry delegates to it. This mirrors the registry
+// in full (rather than a handful of representative blocks per RIR) so
+// providerFor only falls back to ARIN for the handful of /8s IANA still
+// holds in reserve (e.g. 0, 10, 127) or hasn't delegated.
+var ianaIPv4Octets = map[string][]int{
+	"arin": {
+		3, 4, 6, 7, 8, 9, 11, 12, 13, 15, 16, 17, 18, 19, 20, 21, 22, 23, 24,
+		26, 28, 29, 30, 32, 33, 34, 35, 38, 40, 44, 45, 47, 48, 50, 52, 53,
+		54, 55, 56, 63, 64, 65, 66, 67, 68, 69, 70, 71, 72, 73, 74, 75, 76,
+		96, 97, 98, 99, 100, 104, 107, 108, 128, 129, 130, 131, 132, 133,
+		134, 135, 136, 137, 138, 139, 140, 141, 142, 143, 144, 145, 146,
+		147, 148, 149, 150, 151, 155, 156, 158, 159, 160, 161, 162, 164,
+		165, 166, 167, 168, 169, 172, 173, 174, 184, 192, 198, 199, 204,
+		205, 206, 207, 208, 209, 214, 215, 216,
+	},
+	"ripe": {
+		2, 5, 25, 31, 37, 46, 49, 51, 62, 77, 78, 79, 80, 81, 82, 83, 84, 85,
+		86, 87, 88, 89, 90, 91, 92, 93, 94, 95, 109, 157, 176, 178, 185, 188,
+		193, 194, 195, 212, 213, 217,
+	},
+	"apnic": {
+		1, 14, 27, 36, 39, 42, 43, 58, 59, 60, 61, 101, 103, 106, 110, 111,
+		112, 113, 114, 115, 116, 117, 118, 119, 120, 121, 122, 123, 124,
+		125, 126, 153, 171, 175, 180, 182, 183, 202, 203, 210, 211, 218,
+		219, 220, 221, 222, 223,
+	},
+	"lacnic": {
+		170, 177, 179, 181, 186, 187, 189, 190, 191, 200, 201,
+	},
+	"afrinic": {
+		41, 102, 105, 154, 196, 197,
+	},
+}
+
+func buildRIRAllocations() []rirAllocation {
+	endpoints := map[string]string{
+		"arin":    "https://rdap.arin.net/registry/ip/%s",
+		"ripe":    "https://rdap.db.ripe.net/ip/%s",
+		"apnic":   "https://rdap.apnic.net/ip/%s",
+		"lacnic":  "https://rdap.lacnic.net/rdap/ip/%s",
+		"afrinic": "https://rdap.afrinic.net/rdap/ip/%s",
+	}
+
+	var out []rirAllocation
+	for name, octets := range ianaIPv4Octets {
+		var nets []*net.IPNet
+		for _, octet := range octets {
+			_, n, err := net.ParseCIDR(fmt.Sprintf("%d.0.0.0/8", octet))
+			if err != nil {
+				continue
+			}
+			nets = append(nets, n)
+		}
+		out = append(out, rirAllocation{
+			provider: &rdapProvider{name: name, endpoint: endpoints[name]},
+			nets:     nets,
+		})
+	}
+	return out
+}
+
+// providerFor picks the RIR whose delegated space contains ip, falling
+// back to ARIN if none of the known allocations match.
+func providerFor(ip net.IP) WhoisProvider {
+	for _, a := range rirAllocations {
+		for _, n := range a.nets {
+			if n.Contains(ip) {
+				return a.provider
+			}
+		}
+	}
+	return &rdapProvider{name: "arin", endpoint: "https://rdap.arin.net/registry/ip/%s"}
+}
+
+// cymruProvider resolves ASN/prefix/country info in a single UDP DNS
+// query against Team Cymru's IP-to-ASN mapping service, instead of an
+// HTTP round trip to a registry.
+type cymruProvider struct{}
+
+func (p *cymruProvider) Name() string { return "cymru" }
+
+func (p *cymruProvider) Lookup(ip string) (WhoisInfo, error) {
+	var info WhoisInfo
+
+	parsed := net.ParseIP(ip).To4()
+	if parsed == nil {
+		return info, fmt.Errorf("cymru lookup only supports IPv4: %s", ip)
+	}
+
+	reversed := fmt.Sprintf("%d.%d.%d.%d", parsed[3], parsed[2], parsed[1], parsed[0])
+	name := reversed + ".origin.asn.cymru.com"
+
+	txts, err := net.LookupTXT(name)
+	if err != nil {
+		return info, err
+	}
+	if len(txts) == 0 {
+		return info, fmt.Errorf("no TXT records for %s", name)
+	}
+
+	// Format: "ASN | prefix | country | registry | allocated"
+	fields := strings.Split(txts[0], "|")
+	for i := range fields {
+		fields[i] = strings.TrimSpace(fields[i])
+	}
+
+	info.Source = p.Name()
+	if len(fields) > 0 {
+		info.ASN = fields[0]
+	}
+	if len(fields) > 1 {
+		info.Prefix = fields[1]
+	}
+	if len(fields) > 2 {
+		info.Country = fields[2]
+	}
+
+	if len(info.ASN) > 0 {
+		if name, err := lookupCymruASName(info.ASN); err == nil {
+			info.ASName = name
+		}
+	}
+
+	return info, nil
+}
+
+// lookupCymruASName resolves the holder name for asn via Team Cymru's
+// "AS<n>.asn.cymru.com" TXT record, a separate query from the origin
+// lookup since that record only carries the ASN, prefix, and country.
+func lookupCymruASName(asn string) (string, error) {
+	name := "AS" + asn + ".asn.cymru.com"
+
+	txts, err := net.LookupTXT(name)
+	if err != nil {
+		return "", err
+	}
+	if len(txts) == 0 {
+		return "", fmt.Errorf("no TXT records for %s", name)
+	}
+
+	// Format: "ASN | country | registry | allocated | AS name"
+	fields := strings.Split(txts[0], "|")
+	if len(fields) < 5 {
+		return "", fmt.Errorf("unexpected TXT record shape for %s: %q", name, txts[0])
+	}
+
+	return strings.TrimSpace(fields[4]), nil
+}
+
+// whoisCacheEntry is a single cached lookup, keyed by /24.
+type whoisCacheEntry struct {
+	key     string
+	info    WhoisInfo
+	expires time.Time
+}
+
+// whoisCache is an LRU cache with per-entry TTL, keyed by /24, so adjacent
+// IPs found during a scan don't each hit the whois/RDAP endpoints.
+type whoisCache struct {
+	mu    sync.Mutex
+	cap   int
+	ttl   time.Duration
+	order *list.List
+	index map[string]*list.Element
+}
+
+func newWhoisCache(capacity int, ttl time.Duration) *whoisCache {
+	return &whoisCache{
+		cap:   capacity,
+		ttl:   ttl,
+		order: list.New(),
+		index: map[string]*list.Element{},
+	}
+}
+
+func (c *whoisCache) get(key string) (WhoisInfo, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	el, ok := c.index[key]
+	if !ok {
+		return WhoisInfo{}, false
+	}
+
+	entry := el.Value.(*whoisCacheEntry)
+	if time.Now().After(entry.expires) {
+		c.order.Remove(el)
+		delete(c.index, key)
+		return WhoisInfo{}, false
+	}
+
+	c.order.MoveToFront(el)
+	return entry.info, true
+}
+
+func (c *whoisCache) set(key string, info WhoisInfo) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if el, ok := c.index[key]; ok {
+		el.Value.(*whoisCacheEntry).info = info
+		el.Value.(*whoisCacheEntry).expires = time.Now().Add(c.ttl)
+		c.order.MoveToFront(el)
+		return
+	}
+
+	entry := &whoisCacheEntry{key: key, info: info, expires: time.Now().Add(c.ttl)}
+	el := c.order.PushFront(entry)
+	c.index[key] = el
+
+	if c.order.Len() > c.cap {
+		oldest := c.order.Back()
+		if oldest != nil {
+			c.order.Remove(oldest)
+			delete(c.index, oldest.Value.(*whoisCacheEntry).key)
+		}
+	}
+}
+
+func (c *whoisCache) snapshot() map[string]WhoisInfo {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	out := map[string]WhoisInfo{}
+	for key, el := range c.index {
+		entry := el.Value.(*whoisCacheEntry)
+		if time.Now().After(entry.expires) {
+			continue
+		}
+		out[key] = entry.info
+	}
+	return out
+}
+
+func (c *whoisCache) load(path string) error {
+	m, err := loadWhoisCacheFile(path)
+	if err != nil {
+		return err
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for key, info := range m {
+		entry := &whoisCacheEntry{key: key, info: info, expires: time.Now().Add(c.ttl)}
+		el := c.order.PushFront(entry)
+		c.index[key] = el
+	}
+	return nil
+}
+
+func (c *whoisCache) save(path string) error {
+	return saveWhoisCacheFile(path, c.snapshot())
+}
+
+var globalWhoisCache = newWhoisCache(whoisCacheSizeOrDefault(), whoisCacheTTLOrDefault())
+
+// whoisCacheSizeOrDefault and whoisCacheTTLOrDefault exist because package
+// level vars are initialized before flag.Parse runs; initWhoisCache
+// rebuilds the cache with the real flag values once flags are parsed.
+func whoisCacheSizeOrDefault() int          { return 4096 }
+func whoisCacheTTLOrDefault() time.Duration { return 24 * time.Hour }
+
+// initWhoisCache applies the parsed -whois-cache-* flags, loads any
+// existing cache file, and starts the background loop that periodically
+// flushes cache changes back to it. Called from parseFlags.
+func initWhoisCache() {
+	globalWhoisCache = newWhoisCache(whoisCacheSize, whoisCacheTTL)
+
+	if len(whoisCacheFile) == 0 {
+		return
+	}
+
+	if err := globalWhoisCache.load(whoisCacheFile); err != nil {
+		logrus.Debugf("loading -whois-cache-file %s: %v", whoisCacheFile, err)
+	}
+
+	go whoisCacheSaveLoop()
+}
+
+// whoisCacheSaveLoop periodically flushes the whois cache to
+// -whois-cache-file if it has changed since the last save, so concurrent
+// scan workers don't each trigger their own write on every cache miss.
+func whoisCacheSaveLoop() {
+	ticker := time.NewTicker(whoisCacheSaveInterval)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		saveWhoisCacheIfDirty()
+	}
+}
+
+// saveWhoisCacheIfDirty flushes the whois cache to -whois-cache-file if
+// it has been modified since the last save. Safe to call from multiple
+// goroutines, including the shutdown signal handler.
+func saveWhoisCacheIfDirty() {
+	if len(whoisCacheFile) == 0 {
+		return
+	}
+	if !atomic.CompareAndSwapInt32(&whoisCacheDirty, 1, 0) {
+		return
+	}
+	if err := globalWhoisCache.save(whoisCacheFile); err != nil {
+		logrus.Debugf("saving -whois-cache-file %s: %v", whoisCacheFile, err)
+	}
+}
+
+func loadWhoisCacheFile(path string) (map[string]WhoisInfo, error) {
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var m map[string]WhoisInfo
+	if err := json.Unmarshal(b, &m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+// saveWhoisCacheFile writes m to path atomically: it writes to a temp
+// file in the same directory and renames it into place, so a reader (or
+// a concurrent writer) never sees a truncated or interleaved file. Writes
+// are additionally serialized through whoisCacheSaveMu, since this is
+// called from a periodic background loop as well as on shutdown.
+func saveWhoisCacheFile(path string, m map[string]WhoisInfo) error {
+	b, err := json.Marshal(m)
+	if err != nil {
+		return err
+	}
+
+	whoisCacheSaveMu.Lock()
+	defer whoisCacheSaveMu.Unlock()
+
+	tmp := fmt.Sprintf("%s.tmp.%d", path, os.Getpid())
+	if err := ioutil.WriteFile(tmp, b, 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
+
+// getIPInfo looks up allocation info for ip, picking the RIR that owns its
+// space and enriching it with Team Cymru's ASN data, caching the combined
+// result per /24 so a scan doesn't hammer whois endpoints for every hit in
+// the same subnet.
+func getIPInfo(ip string) (WhoisInfo, error) {
+	key := subnetKey(net.ParseIP(ip))
+	if info, ok := globalWhoisCache.get(key); ok {
+		return info, nil
+	}
+
+	parsed := net.ParseIP(ip)
+	if parsed == nil {
+		return WhoisInfo{}, fmt.Errorf("invalid IP: %s", ip)
+	}
+
+	provider := providerFor(parsed)
+	info, err := provider.Lookup(ip)
+	if err != nil {
+		return info, err
+	}
+
+	if cymruInfo, cerr := (&cymruProvider{}).Lookup(ip); cerr == nil {
+		info.ASN = cymruInfo.ASN
+		info.ASName = cymruInfo.ASName
+		info.Prefix = cymruInfo.Prefix
+		if len(info.Country) == 0 {
+			info.Country = cymruInfo.Country
+		}
+	}
+
+	globalWhoisCache.set(key, info)
+	if len(whoisCacheFile) > 0 {
+		atomic.StoreInt32(&whoisCacheDirty, 1)
+	}
+
+	return info, nil
+}